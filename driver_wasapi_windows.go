@@ -23,9 +23,225 @@ import (
 
 	"golang.org/x/sys/windows"
 
+	"github.com/hajimehoshi/oto/v2/internal/resample"
 	"github.com/hajimehoshi/oto/v2/mux"
 )
 
+// Device represents an audio render endpoint that can be selected
+// explicitly when creating a context.
+type Device struct {
+	// ID is the endpoint ID string as returned by IMMDevice::GetId.
+	// It can be passed verbatim as the DeviceID field of WASAPIContextOptions.
+	ID string
+
+	// Name is the endpoint's friendly name, read from its property store
+	// via PKEY_Device_FriendlyName.
+	Name string
+
+	// IsDefault reports whether this is the default render endpoint for
+	// the console role (eConsole).
+	IsDefault bool
+
+	// IsDefaultCommunications reports whether this is the default render
+	// endpoint for the communications role (eCommunications).
+	IsDefaultCommunications bool
+}
+
+// EnumerateDevices returns the list of active audio render endpoints known
+// to WASAPI. The returned Device.ID values can be used as DeviceID in
+// WASAPIContextOptions to select a specific output device.
+//
+// EnumerateDevices is specific to Windows.
+func EnumerateDevices() ([]Device, error) {
+	t, err := newCOMThread()
+	if err != nil {
+		return nil, err
+	}
+	defer close(t.funcCh)
+
+	var devices []Device
+	var cerr error
+	t.Run(func() {
+		devices, cerr = enumerateDevicesOnCOMThread()
+	})
+	if cerr != nil {
+		return nil, cerr
+	}
+	return devices, nil
+}
+
+func enumerateDevicesOnCOMThread() ([]Device, error) {
+	e, err := _CoCreateInstance(&uuidMMDeviceEnumerator, nil, uint32(_CLSCTX_ALL), &uuidIMMDeviceEnumerator)
+	if err != nil {
+		return nil, err
+	}
+	enumerator := (*_IMMDeviceEnumerator)(e)
+	defer enumerator.Release()
+
+	defaultConsole, err := defaultDeviceID(enumerator, eConsole)
+	if err != nil {
+		return nil, err
+	}
+	defaultComms, err := defaultDeviceID(enumerator, eCommunications)
+	if err != nil {
+		return nil, err
+	}
+
+	collection, err := enumerator.EnumAudioEndpoints(eRender, _DEVICE_STATE_ACTIVE)
+	if err != nil {
+		return nil, err
+	}
+	defer collection.Release()
+
+	count, err := collection.GetCount()
+	if err != nil {
+		return nil, err
+	}
+
+	devices := make([]Device, 0, count)
+	for i := uint32(0); i < count; i++ {
+		device, err := collection.Item(i)
+		if err != nil {
+			return nil, err
+		}
+
+		id, err := device.GetId()
+		if err != nil {
+			device.Release()
+			return nil, err
+		}
+
+		name, err := deviceFriendlyName(device)
+		if err != nil {
+			device.Release()
+			return nil, err
+		}
+		device.Release()
+
+		devices = append(devices, Device{
+			ID:                      id,
+			Name:                    name,
+			IsDefault:               id == defaultConsole,
+			IsDefaultCommunications: id == defaultComms,
+		})
+	}
+
+	return devices, nil
+}
+
+func defaultDeviceID(enumerator *_IMMDeviceEnumerator, role _ERole) (string, error) {
+	device, err := enumerator.GetDefaultAudioEndPoint(eRender, role)
+	if err != nil {
+		// There might be no default device for this role (e.g. no audio
+		// hardware at all). Treat this as 'no default' rather than an error.
+		return "", nil
+	}
+	defer device.Release()
+	return device.GetId()
+}
+
+func deviceFriendlyName(device *_IMMDevice) (string, error) {
+	store, err := device.OpenPropertyStore(_STGM_READ)
+	if err != nil {
+		return "", err
+	}
+	defer store.Release()
+
+	v, err := store.GetValue(&_PKEY_Device_FriendlyName)
+	if err != nil {
+		return "", err
+	}
+	return v, nil
+}
+
+// deviceNotificationClient implements the IMMNotificationClient COM
+// interface so the WASAPI backend can detect default-device changes,
+// device arrival/removal, and state transitions (e.g. unplugging a USB
+// headset) and reroute playback to the new default endpoint automatically.
+type deviceNotificationClient struct {
+	vtbl *deviceNotificationClientVtbl
+	ctx  *wasapiContext
+}
+
+type deviceNotificationClientVtbl struct {
+	queryInterface         uintptr
+	addRef                 uintptr
+	release                uintptr
+	onDeviceStateChanged   uintptr
+	onDeviceAdded          uintptr
+	onDeviceRemoved        uintptr
+	onDefaultDeviceChanged uintptr
+	onPropertyValueChanged uintptr
+}
+
+var theDeviceNotificationClientVtbl = &deviceNotificationClientVtbl{
+	queryInterface:         windows.NewCallback(deviceNotificationClientQueryInterface),
+	addRef:                 windows.NewCallback(deviceNotificationClientAddRef),
+	release:                windows.NewCallback(deviceNotificationClientRelease),
+	onDeviceStateChanged:   windows.NewCallback(deviceNotificationClientOnDeviceStateChanged),
+	onDeviceAdded:          windows.NewCallback(deviceNotificationClientOnDeviceAdded),
+	onDeviceRemoved:        windows.NewCallback(deviceNotificationClientOnDeviceRemoved),
+	onDefaultDeviceChanged: windows.NewCallback(deviceNotificationClientOnDefaultDeviceChanged),
+	onPropertyValueChanged: windows.NewCallback(deviceNotificationClientOnPropertyValueChanged),
+}
+
+func newDeviceNotificationClient(ctx *wasapiContext) *deviceNotificationClient {
+	return &deviceNotificationClient{
+		vtbl: theDeviceNotificationClientVtbl,
+		ctx:  ctx,
+	}
+}
+
+// This is a fixed, minimal IUnknown implementation: the lifetime of a
+// deviceNotificationClient is tied to its wasapiContext, not to COM
+// reference counting.
+
+func deviceNotificationClientQueryInterface(this, _ /* riid */, ppvObject uintptr) uintptr {
+	if ppvObject != 0 {
+		*(*uintptr)(unsafe.Pointer(ppvObject)) = this
+	}
+	return 0 // S_OK
+}
+
+func deviceNotificationClientAddRef(uintptr) uintptr {
+	return 1
+}
+
+func deviceNotificationClientRelease(uintptr) uintptr {
+	return 1
+}
+
+func deviceNotificationClientOnDeviceStateChanged(this, _ /* deviceID */, _ /* newState */ uintptr) uintptr {
+	c := (*deviceNotificationClient)(unsafe.Pointer(this))
+	c.ctx.onDeviceAvailabilityChanged()
+	return 0 // S_OK
+}
+
+func deviceNotificationClientOnDeviceAdded(this, _ /* deviceID */ uintptr) uintptr {
+	c := (*deviceNotificationClient)(unsafe.Pointer(this))
+	c.ctx.onDeviceAvailabilityChanged()
+	return 0 // S_OK
+}
+
+func deviceNotificationClientOnDeviceRemoved(this, _ /* deviceID */ uintptr) uintptr {
+	c := (*deviceNotificationClient)(unsafe.Pointer(this))
+	c.ctx.onDeviceAvailabilityChanged()
+	return 0 // S_OK
+}
+
+func deviceNotificationClientOnDefaultDeviceChanged(this, flow, role, _ /* deviceID */ uintptr) uintptr {
+	if _EDataFlow(flow) != eRender || _ERole(role) != eConsole {
+		return 0 // S_OK
+	}
+	c := (*deviceNotificationClient)(unsafe.Pointer(this))
+	c.ctx.onDeviceAvailabilityChanged()
+	return 0 // S_OK
+}
+
+func deviceNotificationClientOnPropertyValueChanged(this, _ /* deviceID */, _ /* key */ uintptr) uintptr {
+	return 0 // S_OK
+}
+
 type comThread struct {
 	funcCh chan func()
 }
@@ -67,36 +283,176 @@ func (c *comThread) Run(f func()) {
 	<-ch
 }
 
+// LatencyHint selects the tradeoff between latency and power/CPU usage for
+// the WASAPI backend's shared-mode buffer, when negotiated via
+// IAudioClient3.
+type LatencyHint int
+
+const (
+	// LatencyInteractive requests the smallest period the engine and
+	// driver support, via IAudioClient3.GetSharedModeEnginePeriod. This is
+	// the right choice for games and instruments, where 100ms+ of
+	// buffering is unacceptable. This is the default.
+	LatencyInteractive LatencyHint = iota
+
+	// LatencyBalanced uses the engine's default period.
+	LatencyBalanced
+
+	// LatencyPowerSaver requests the largest period the engine and driver
+	// support, trading latency for fewer wake-ups.
+	LatencyPowerSaver
+)
+
+// WASAPIContextOptions configures aspects of the WASAPI backend beyond the
+// sample rate and channel count every platform accepts.
+type WASAPIContextOptions struct {
+	// DeviceID, when non-empty, is the ID of the render endpoint to open,
+	// as returned by EnumerateDevices. An empty DeviceID opens the
+	// default render endpoint.
+	DeviceID string
+
+	// LatencyHint selects the latency/power tradeoff used when opening
+	// the stream in shared mode. It has no effect when Exclusive is true.
+	LatencyHint LatencyHint
+
+	// Exclusive opens the render endpoint in AUDCLNT_SHAREMODE_EXCLUSIVE,
+	// giving this stream sole ownership of the device. This usually
+	// offers the lowest possible latency, but silences every other
+	// application's audio while the stream is running.
+	Exclusive bool
+
+	// MMCSSTaskName registers the render thread with the Multimedia Class
+	// Scheduler Service under this task name (e.g. "Audio", "Pro Audio",
+	// "Games"), so Windows schedules it appropriately under CPU load. The
+	// zero value uses "Pro Audio". Set DisableMMCSS to opt out entirely.
+	MMCSSTaskName string
+
+	// DisableMMCSS disables MMCSS thread characterization, even though
+	// MMCSSTaskName defaults to "Pro Audio" when left unset.
+	DisableMMCSS bool
+}
+
+var (
+	modavrt                             = windows.NewLazySystemDLL("avrt.dll")
+	procAvSetMmThreadCharacteristicsW   = modavrt.NewProc("AvSetMmThreadCharacteristicsW")
+	procAvRevertMmThreadCharacteristics = modavrt.NewProc("AvRevertMmThreadCharacteristics")
+)
+
+// avSetMmThreadCharacteristics registers the calling thread with MMCSS
+// under the given task name and returns a handle to be passed to
+// avRevertMmThreadCharacteristics once the thread is done.
+func avSetMmThreadCharacteristics(taskName string) (windows.Handle, error) {
+	name, err := windows.UTF16PtrFromString(taskName)
+	if err != nil {
+		return 0, err
+	}
+
+	var taskIndex uint32
+	r, _, err := procAvSetMmThreadCharacteristicsW.Call(uintptr(unsafe.Pointer(name)), uintptr(unsafe.Pointer(&taskIndex)))
+	if r == 0 {
+		return 0, err
+	}
+	return windows.Handle(r), nil
+}
+
+func avRevertMmThreadCharacteristics(h windows.Handle) error {
+	r, _, err := procAvRevertMmThreadCharacteristics.Call(uintptr(h))
+	if r == 0 {
+		return err
+	}
+	return nil
+}
+
+// wasapiRenderGeneration holds every COM object and piece of mutable state
+// belonging to one "instance" of playback against a render endpoint. A new
+// generation is created each time initOnCOMThread (re-)opens the device,
+// e.g. after a device-change reroute.
+//
+// A generation's fields are only ever touched by its own render goroutine
+// (loopOnRenderThread/writeOnRenderThread, under wasapiContext.m), by
+// Suspend/Resume (which call client.Stop/Start on the COM thread under
+// wasapiContext.m), and by whichever COM-thread call created or is
+// retiring it; reinitOnCOMThread
+// always waits on done for the render goroutine to exit before releasing
+// or closing anything a generation owns, so nothing reads these fields
+// after they stop being valid.
+type wasapiRenderGeneration struct {
+	client       *_IAudioClient2
+	renderClient *_IAudioRenderClient
+	mixFormat    *_WAVEFORMATEXTENSIBLE
+
+	sampleReadyEvent windows.Handle
+	stopEvent        windows.Handle // signaled to ask the render goroutine to exit
+
+	bufferFrames uint32
+	periodFrames uint32 // 0 means the period was not negotiated via IAudioClient3 (shared-mode default, or exclusive mode)
+
+	// deviceChannelCount is the channel count oto actually writes to
+	// renderClient. It equals wasapiContext.channelCount unless resampler
+	// is non-nil, in which case it's the device's native channel count
+	// from its mix format.
+	deviceChannelCount int
+	resampler          *resample.Resampler
+
+	buf []float32
+
+	done chan struct{} // closed once the render goroutine for this generation has exited
+}
+
 type wasapiContext struct {
-	sampleRate   int
-	channelCount int
-	mux          *mux.Mux
+	sampleRate    int
+	channelCount  int
+	deviceID      string
+	latencyHint   LatencyHint
+	exclusive     bool
+	mmcssTaskName string
+	mux           *mux.Mux
 
 	comThread *comThread
 	err       atomicError
 
-	sampleReadyEvent windows.Handle
-	client           *_IAudioClient2
-	mixFormat        *_WAVEFORMATEXTENSIBLE
-	bufferFrames     uint32
-	renderClient     *_IAudioRenderClient
+	// enumerator and notifyClient are created once and kept alive for the
+	// lifetime of the context so they can notify us of default-device
+	// changes. They are independent of gen, which is torn down and
+	// recreated whenever the render device changes.
+	enumerator   *_IMMDeviceEnumerator
+	notifyClient *deviceNotificationClient
 
-	buf []float32
+	// onDeviceChanged, if set, is called after playback has been re-opened
+	// on the default render endpoint in response to a device notification.
+	onDeviceChanged func()
+
+	// gen is the current render generation. It's replaced, never mutated,
+	// by initOnCOMThread, so once a goroutine has read it under m it can
+	// keep using the value without racing a later reroute.
+	gen *wasapiRenderGeneration
 
 	m sync.Mutex
 }
 
-func newWASAPIContext(sampleRate, channelCount int, mux *mux.Mux) (*wasapiContext, error) {
+func newWASAPIContext(sampleRate, channelCount int, options WASAPIContextOptions, mux *mux.Mux) (*wasapiContext, error) {
 	t, err := newCOMThread()
 	if err != nil {
 		return nil, err
 	}
 
+	mmcssTaskName := options.MMCSSTaskName
+	if mmcssTaskName == "" {
+		mmcssTaskName = "Pro Audio"
+	}
+	if options.DisableMMCSS {
+		mmcssTaskName = ""
+	}
+
 	c := &wasapiContext{
-		sampleRate:   sampleRate,
-		channelCount: channelCount,
-		mux:          mux,
-		comThread:    t,
+		sampleRate:    sampleRate,
+		channelCount:  channelCount,
+		deviceID:      options.DeviceID,
+		latencyHint:   options.LatencyHint,
+		exclusive:     options.Exclusive,
+		mmcssTaskName: mmcssTaskName,
+		mux:           mux,
+		comThread:     t,
 	}
 
 	var cerr error
@@ -105,6 +461,10 @@ func newWASAPIContext(sampleRate, channelCount int, mux *mux.Mux) (*wasapiContex
 			cerr = err
 			return
 		}
+		if err := c.registerDeviceNotificationsOnCOMThread(); err != nil {
+			cerr = err
+			return
+		}
 	})
 	if cerr != nil {
 		return nil, cerr
@@ -113,6 +473,94 @@ func newWASAPIContext(sampleRate, channelCount int, mux *mux.Mux) (*wasapiContex
 	return c, nil
 }
 
+// SetOnDeviceChangedCallback sets a function to be called after playback
+// has been automatically re-opened on the default render endpoint in
+// response to a device notification, e.g. after the user unplugs the active
+// headset or changes the default device in Windows' sound settings. The
+// endpoint may be the same one as before, since any added, removed or
+// state-changed device triggers this. f may be nil.
+func (c *wasapiContext) SetOnDeviceChangedCallback(f func()) {
+	c.m.Lock()
+	defer c.m.Unlock()
+	c.onDeviceChanged = f
+}
+
+func (c *wasapiContext) registerDeviceNotificationsOnCOMThread() error {
+	e, err := _CoCreateInstance(&uuidMMDeviceEnumerator, nil, uint32(_CLSCTX_ALL), &uuidIMMDeviceEnumerator)
+	if err != nil {
+		return err
+	}
+	enumerator := (*_IMMDeviceEnumerator)(e)
+
+	nc := newDeviceNotificationClient(c)
+	if err := enumerator.RegisterEndpointNotificationCallback(nc); err != nil {
+		enumerator.Release()
+		return err
+	}
+
+	c.enumerator = enumerator
+	c.notifyClient = nc
+	return nil
+}
+
+// onDeviceAvailabilityChanged is called on an arbitrary MMDevAPI thread
+// whenever the default console render endpoint changes, or any endpoint is
+// added, removed or changes state. When no DeviceID was given, every such
+// notification re-opens playback on the current default render endpoint,
+// whichever device it concerns. When a DeviceID was given, notifications
+// are ignored, including the removal of that device.
+func (c *wasapiContext) onDeviceAvailabilityChanged() {
+	c.comThread.Run(func() {
+		if c.deviceID != "" {
+			// The user pinned a specific device; don't reroute away from it.
+			return
+		}
+		if err := c.reinitOnCOMThread(); err != nil {
+			c.err.TryStore(err)
+			return
+		}
+
+		c.m.Lock()
+		f := c.onDeviceChanged
+		c.m.Unlock()
+		if f != nil {
+			f()
+		}
+	})
+}
+
+// reinitOnCOMThread tears down the current client/renderClient and
+// re-initializes them against the (possibly new) default render endpoint.
+// It must be called on the COM thread.
+// reinitOnCOMThread retires the current render generation and initializes a
+// new one against the (possibly new) default render endpoint. It must be
+// called on the COM thread.
+//
+// Closing a handle or releasing a COM object that another thread is
+// blocked on is undefined behavior, so this signals the old generation's
+// render goroutine to stop via its stopEvent and waits for done to close
+// before touching anything the goroutine might still be using.
+func (c *wasapiContext) reinitOnCOMThread() error {
+	c.m.Lock()
+	old := c.gen
+	c.m.Unlock()
+
+	if old != nil {
+		windows.SetEvent(old.stopEvent)
+		<-old.done
+
+		old.client.Stop()
+		if old.renderClient != nil {
+			old.renderClient.Release()
+		}
+		old.client.Release()
+		windows.CloseHandle(old.sampleReadyEvent)
+		windows.CloseHandle(old.stopEvent)
+	}
+
+	return c.initOnCOMThread()
+}
+
 func (c *wasapiContext) initOnCOMThread() error {
 	e, err := _CoCreateInstance(&uuidMMDeviceEnumerator, nil, uint32(_CLSCTX_ALL), &uuidIMMDeviceEnumerator)
 	if err != nil {
@@ -121,7 +569,12 @@ func (c *wasapiContext) initOnCOMThread() error {
 	enumerator := (*_IMMDeviceEnumerator)(e)
 	defer enumerator.Release()
 
-	device, err := enumerator.GetDefaultAudioEndPoint(eRender, eConsole)
+	var device *_IMMDevice
+	if c.deviceID != "" {
+		device, err = enumerator.GetDevice(c.deviceID)
+	} else {
+		device, err = enumerator.GetDefaultAudioEndPoint(eRender, eConsole)
+	}
 	if err != nil {
 		return err
 	}
@@ -131,9 +584,13 @@ func (c *wasapiContext) initOnCOMThread() error {
 	if err != nil {
 		return err
 	}
-	c.client = (*_IAudioClient2)(client)
 
-	if err := c.client.SetClientProperties(&_AudioClientProperties{
+	gen := &wasapiRenderGeneration{
+		client: (*_IAudioClient2)(client),
+		done:   make(chan struct{}),
+	}
+
+	if err := gen.client.SetClientProperties(&_AudioClientProperties{
 		cbSize:     uint32(unsafe.Sizeof(_AudioClientProperties{})),
 		bIsOffload: 0,                    // false
 		eCategory:  _AudioCategory_Other, // In the example, AudioCategory_ForegroundOnlyMedia was used, but this value is deprecated.
@@ -141,9 +598,10 @@ func (c *wasapiContext) initOnCOMThread() error {
 		return err
 	}
 
-	// Check the format is supported by WASAPI.
-	// Stereo with 48000 [Hz] is likely supported, but mono and/or other sample rates are unlikely supported.
-	// Fallback to WinMM in this case anyway.
+	// Check whether the application's preferred format is supported by
+	// WASAPI directly. Stereo with 48000 [Hz] is likely supported, but
+	// mono and/or other sample rates are unlikely supported; in that case
+	// we fall back to the device's native format below and resample.
 	const bitsPerSample = 32
 	nBlockAlign := c.channelCount * bitsPerSample / 8
 	var channelMask uint32
@@ -165,62 +623,126 @@ func (c *wasapiContext) initOnCOMThread() error {
 		dwChannelMask:   channelMask,
 		SubFormat:       _KSDATAFORMAT_SUBTYPE_IEEE_FLOAT,
 	}
-	closest, err := c.client.IsFormatSupported(_AUDCLNT_SHAREMODE_SHARED, f)
+	shareMode := _AUDCLNT_SHAREMODE_SHARED
+	if c.exclusive {
+		shareMode = _AUDCLNT_SHAREMODE_EXCLUSIVE
+	}
+
+	closest, err := gen.client.IsFormatSupported(shareMode, f)
 	if err != nil {
 		return err
 	}
+
+	gen.deviceChannelCount = c.channelCount
+
 	if closest != nil {
-		return fmt.Errorf("oto: the specified format is not supported (there is the closest format instead)")
+		// The device doesn't support the application's requested format.
+		// Rather than fail outright, fall back to whatever format the
+		// device actually wants and resample/remix into it on the fly.
+		// Exclusive mode requires an exact match, so there is no fallback
+		// there.
+		if c.exclusive {
+			return fmt.Errorf("oto: the specified format is not supported (there is the closest format instead)")
+		}
+
+		devFormat, err := gen.client.GetMixFormat()
+		if err != nil {
+			return err
+		}
+
+		gen.mixFormat = devFormat
+		gen.deviceChannelCount = int(devFormat.nChannels)
+		gen.resampler = resample.New(c.sampleRate, c.channelCount, int(devFormat.nSamplesPerSec), gen.deviceChannelCount)
+	} else {
+		gen.mixFormat = f
 	}
-	c.mixFormat = f
 
-	if err := c.client.Initialize(_AUDCLNT_SHAREMODE_SHARED,
-		_AUDCLNT_STREAMFLAGS_EVENTCALLBACK|_AUDCLNT_STREAMFLAGS_NOPERSIST,
-		0, 0, c.mixFormat, nil); err != nil {
-		return err
+	const streamFlags = _AUDCLNT_STREAMFLAGS_EVENTCALLBACK | _AUDCLNT_STREAMFLAGS_NOPERSIST
+
+	switch {
+	case c.exclusive:
+		if err := c.initExclusiveOnCOMThread(gen, streamFlags); err != nil {
+			return err
+		}
+	case c.latencyHint != LatencyBalanced:
+		// Try to negotiate a non-default shared-mode engine period via
+		// IAudioClient3. If the engine or driver rejects it, fall back to
+		// the driver-chosen default period below.
+		if err := c.initLowLatencySharedOnCOMThread(gen, streamFlags); err != nil {
+			if err := gen.client.Initialize(_AUDCLNT_SHAREMODE_SHARED, streamFlags, 0, 0, gen.mixFormat, nil); err != nil {
+				return err
+			}
+		}
+	default:
+		if err := gen.client.Initialize(_AUDCLNT_SHAREMODE_SHARED, streamFlags, 0, 0, gen.mixFormat, nil); err != nil {
+			return err
+		}
 	}
 
-	frames, err := c.client.GetBufferSize()
+	frames, err := gen.client.GetBufferSize()
 	if err != nil {
 		return err
 	}
-	c.bufferFrames = frames
+	gen.bufferFrames = frames
 
-	renderClient, err := c.client.GetService(&uuidIAudioRenderClient)
+	renderClient, err := gen.client.GetService(&uuidIAudioRenderClient)
 	if err != nil {
 		return err
 	}
-	c.renderClient = (*_IAudioRenderClient)(renderClient)
+	gen.renderClient = (*_IAudioRenderClient)(renderClient)
 
 	ev, err := windows.CreateEventEx(nil, nil, 0, windows.EVENT_ALL_ACCESS)
 	if err != nil {
 		return err
 	}
-	c.sampleReadyEvent = ev
+	gen.sampleReadyEvent = ev
+
+	stopEv, err := windows.CreateEventEx(nil, nil, 0, windows.EVENT_ALL_ACCESS)
+	if err != nil {
+		return err
+	}
+	gen.stopEvent = stopEv
 
-	if err := c.client.SetEventHandle(c.sampleReadyEvent); err != nil {
+	if err := gen.client.SetEventHandle(gen.sampleReadyEvent); err != nil {
 		return err
 	}
 
 	// TODO: Should some errors be allowed? See WASAPIManager.cpp in the official example SimpleWASAPIPlaySound.
 
-	if err := c.client.Start(); err != nil {
+	if err := gen.client.Start(); err != nil {
 		return err
 	}
 
+	c.m.Lock()
+	c.gen = gen
+	c.m.Unlock()
+
 	go func() {
+		defer close(gen.done)
+
 		runtime.LockOSThread()
 		defer runtime.UnlockOSThread()
 
+		// Register this thread with MMCSS so Windows schedules it with
+		// pro-audio priority instead of letting it be preempted under CPU
+		// load, which would otherwise cause audible glitches. Failure to
+		// register (e.g. under a locked-down service account) is not
+		// fatal; playback just continues without the priority boost.
+		if c.mmcssTaskName != "" {
+			if h, err := avSetMmThreadCharacteristics(c.mmcssTaskName); err == nil {
+				defer avRevertMmThreadCharacteristics(h)
+			}
+		}
+
 		if err := _CoInitializeEx(nil, _COINIT_MULTITHREADED); err != nil {
-			c.client.Stop()
+			gen.client.Stop()
 			c.err.TryStore(err)
 			return
 		}
 		defer _CoUninitialize()
 
-		if err := c.loopOnRenderThread(); err != nil {
-			c.client.Stop()
+		if err := c.loopOnRenderThread(gen); err != nil {
+			gen.client.Stop()
 			c.err.TryStore(err)
 			return
 		}
@@ -229,52 +751,134 @@ func (c *wasapiContext) initOnCOMThread() error {
 	return nil
 }
 
-func (c *wasapiContext) loopOnRenderThread() error {
-	for {
-		evt, err := windows.WaitForSingleObject(c.sampleReadyEvent, windows.INFINITE)
-		if err != nil {
-			return err
+// initExclusiveOnCOMThread initializes gen.client in
+// AUDCLNT_SHAREMODE_EXCLUSIVE using the device's minimum supported period,
+// for the lowest latency WASAPI can offer. It must be called on the COM
+// thread.
+func (c *wasapiContext) initExclusiveOnCOMThread(gen *wasapiRenderGeneration, streamFlags uint32) error {
+	_, minPeriod, err := gen.client.GetDevicePeriod()
+	if err != nil {
+		return err
+	}
+
+	if err := gen.client.Initialize(_AUDCLNT_SHAREMODE_EXCLUSIVE, streamFlags, minPeriod, minPeriod, gen.mixFormat, nil); err != nil {
+		return err
+	}
+
+	gen.periodFrames = 0
+	return nil
+}
+
+// initLowLatencySharedOnCOMThread negotiates a shared-mode engine period via
+// IAudioClient3, according to c.latencyHint, and initializes gen.client with
+// it. It must be called on the COM thread. On any failure the caller should
+// fall back to the standard IAudioClient2.Initialize shared-mode path.
+func (c *wasapiContext) initLowLatencySharedOnCOMThread(gen *wasapiRenderGeneration, streamFlags uint32) error {
+	p, err := gen.client.QueryInterface(&uuidIAudioClient3)
+	if err != nil {
+		return err
+	}
+	client3 := (*_IAudioClient3)(p)
+	defer client3.Release()
+
+	defaultPeriod, fundamentalPeriod, minPeriod, maxPeriod, err := client3.GetSharedModeEnginePeriod(gen.mixFormat)
+	if err != nil {
+		return err
+	}
+
+	period := defaultPeriod
+	switch c.latencyHint {
+	case LatencyInteractive:
+		period = minPeriod
+	case LatencyPowerSaver:
+		period = maxPeriod
+	}
+
+	// The negotiated period must be a multiple of the fundamental period.
+	if fundamentalPeriod > 0 {
+		if rem := period % fundamentalPeriod; rem != 0 {
+			period += fundamentalPeriod - rem
+		}
+		if period > maxPeriod {
+			period = maxPeriod
 		}
-		if evt != windows.WAIT_OBJECT_0 {
-			return fmt.Errorf("oto: WaitForSingleObject failed: returned value: %d", evt)
+		if period < minPeriod {
+			period = minPeriod
 		}
+	}
 
-		if err := c.writeOnRenderThread(); err != nil {
+	if err := client3.InitializeSharedAudioStream(streamFlags, period, gen.mixFormat, nil); err != nil {
+		return err
+	}
+
+	gen.periodFrames = period
+	return nil
+}
+
+// loopOnRenderThread runs the render loop for one render generation. gen is
+// only ever touched by this goroutine, by Suspend/Resume (under
+// wasapiContext.m), and by reinitOnCOMThread, which waits for gen.done
+// before touching it again, so there is nothing else to synchronize on
+// besides wasapiContext.m, which guards the COM calls in
+// writeOnRenderThread.
+func (c *wasapiContext) loopOnRenderThread(gen *wasapiRenderGeneration) error {
+	handles := []windows.Handle{gen.sampleReadyEvent, gen.stopEvent}
+	for {
+		evt, err := windows.WaitForMultipleObjects(handles, false, windows.INFINITE)
+		if err != nil {
 			return err
 		}
+		switch evt {
+		case windows.WAIT_OBJECT_0:
+			if err := c.writeOnRenderThread(gen); err != nil {
+				return err
+			}
+		case windows.WAIT_OBJECT_0 + 1:
+			// reinitOnCOMThread asked us to stop, e.g. to reroute playback
+			// after a device change.
+			return nil
+		default:
+			return fmt.Errorf("oto: WaitForMultipleObjects failed: returned value: %d", evt)
+		}
 	}
 }
 
-func (c *wasapiContext) writeOnRenderThread() error {
+func (c *wasapiContext) writeOnRenderThread(gen *wasapiRenderGeneration) error {
 	c.m.Lock()
 	defer c.m.Unlock()
 
-	paddingFrames, err := c.client.GetCurrentPadding()
+	paddingFrames, err := gen.client.GetCurrentPadding()
 	if err != nil {
 		return err
 	}
 
-	frames := c.bufferFrames - paddingFrames
+	frames := gen.bufferFrames - paddingFrames
 	if frames <= 0 {
 		return nil
 	}
 
 	// Get the destination buffer.
-	dstBuf, err := c.renderClient.GetBuffer(frames)
+	dstBuf, err := gen.renderClient.GetBuffer(frames)
 	if err != nil {
 		return err
 	}
 
-	// Calculate the buffer size.
-	buflen := int(frames) * c.channelCount
-	if cap(c.buf) < buflen {
-		c.buf = make([]float32, buflen)
+	// Calculate the buffer size. This is in the device's channel count,
+	// which differs from c.channelCount when gen.resampler is in use.
+	buflen := int(frames) * gen.deviceChannelCount
+	if cap(gen.buf) < buflen {
+		gen.buf = make([]float32, buflen)
 	} else {
-		c.buf = c.buf[:buflen]
+		gen.buf = gen.buf[:buflen]
 	}
 
-	// Read the buffer from the players.
-	c.mux.ReadFloat32s(c.buf)
+	// Read the buffer from the players, resampling and remixing into the
+	// device's native format if it doesn't match the application's.
+	if gen.resampler != nil {
+		gen.resampler.Read(gen.buf, c.mux.ReadFloat32s)
+	} else {
+		c.mux.ReadFloat32s(gen.buf)
+	}
 
 	// Copy the read buf to the destination buffer.
 	var dst []float32
@@ -282,14 +886,14 @@ func (c *wasapiContext) writeOnRenderThread() error {
 	h.Data = uintptr(unsafe.Pointer(dstBuf))
 	h.Len = buflen
 	h.Cap = buflen
-	copy(dst, c.buf)
+	copy(dst, gen.buf)
 
 	// Release the buffer.
-	if err := c.renderClient.ReleaseBuffer(frames, 0); err != nil {
+	if err := gen.renderClient.ReleaseBuffer(frames, 0); err != nil {
 		return err
 	}
 
-	c.buf = c.buf[:0]
+	gen.buf = gen.buf[:0]
 	return nil
 }
 
@@ -299,7 +903,10 @@ func (c *wasapiContext) Suspend() error {
 		c.m.Lock()
 		defer c.m.Unlock()
 
-		if err := c.client.Stop(); err != nil {
+		if c.gen == nil {
+			return
+		}
+		if err := c.gen.client.Stop(); err != nil {
 			cerr = err
 			return
 		}
@@ -313,7 +920,10 @@ func (c *wasapiContext) Resume() error {
 		c.m.Lock()
 		defer c.m.Unlock()
 
-		if err := c.client.Start(); err != nil {
+		if c.gen == nil {
+			return
+		}
+		if err := c.gen.client.Start(); err != nil {
 			cerr = err
 			return
 		}
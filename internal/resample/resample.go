@@ -0,0 +1,173 @@
+// Copyright 2022 The Oto Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package resample converts a stream of interleaved float32 PCM samples
+// between sample rates and channel counts. It exists so platform backends
+// can keep playing an application's preferred format even when the audio
+// device requires a different one, instead of failing outright.
+package resample
+
+// blockFrames is how many source frames Resampler reads from its source at
+// once. Reading a whole block at a time, rather than one source frame per
+// output sample, keeps read (typically the whole player mixer) from being
+// called hundreds of times per render callback.
+const blockFrames = 64
+
+// Resampler converts PCM audio from (SrcRate, SrcChannels) to
+// (DstRate, DstChannels) using linear interpolation for the rate
+// conversion and fixed coefficients for channel up/down-mixing. It is not
+// intended to be hi-fi; it is intended to keep audio playing when a
+// device's native format doesn't match what the application asked for.
+type Resampler struct {
+	srcRate, dstRate         int
+	srcChannels, dstChannels int
+
+	pos float64 // fractional frame position of the next output sample, relative to block
+
+	// block holds blockFrames+1 source frames: frame 0 is the last frame of
+	// the previous block, carried over so interpolation has a "prev" frame
+	// right at the block boundary, and frames 1..blockFrames are freshly
+	// read by fillBlock.
+	block  []float32
+	primed bool
+
+	interp []float32 // scratch: the interpolated source frame before remixing
+}
+
+// New returns a Resampler converting from (srcRate, srcChannels) to
+// (dstRate, dstChannels).
+func New(srcRate, srcChannels, dstRate, dstChannels int) *Resampler {
+	return &Resampler{
+		srcRate:     srcRate,
+		srcChannels: srcChannels,
+		dstRate:     dstRate,
+		dstChannels: dstChannels,
+		block:       make([]float32, (blockFrames+1)*srcChannels),
+		interp:      make([]float32, srcChannels),
+	}
+}
+
+// Read fills dst, whose length must be a multiple of DstChannels, with
+// resampled and remixed audio. It calls read zero or more times to obtain
+// blocks of source audio; like mux.Mux.ReadFloat32s, read must fill its
+// argument completely.
+func (r *Resampler) Read(dst []float32, read func([]float32)) {
+	if !r.primed {
+		r.fillBlock(read)
+		copy(r.frame(0), r.frame(1))
+		r.primed = true
+	}
+
+	ratio := float64(r.srcRate) / float64(r.dstRate)
+	dstFrames := len(dst) / r.dstChannels
+
+	for i := 0; i < dstFrames; i++ {
+		for int(r.pos) >= blockFrames {
+			// Carry the last frame of this block forward as frame 0 of the
+			// next, so interpolation stays continuous across the block
+			// boundary.
+			copy(r.frame(0), r.frame(blockFrames))
+			r.fillBlock(read)
+			r.pos -= blockFrames
+		}
+
+		base := int(r.pos)
+		prev, next := r.frame(base), r.frame(base+1)
+		frac := float32(r.pos - float64(base))
+		for ch := range r.interp {
+			r.interp[ch] = prev[ch] + (next[ch]-prev[ch])*frac
+		}
+
+		remix(dst[i*r.dstChannels:(i+1)*r.dstChannels], r.interp)
+		r.pos += ratio
+	}
+}
+
+// frame returns the i-th source frame in r.block as a srcChannels-length
+// slice.
+func (r *Resampler) frame(i int) []float32 {
+	return r.block[i*r.srcChannels : (i+1)*r.srcChannels]
+}
+
+// fillBlock reads blockFrames fresh source frames into r.block, after its
+// carried-over frame 0.
+func (r *Resampler) fillBlock(read func([]float32)) {
+	read(r.block[r.srcChannels:])
+}
+
+// remix writes src (len(src) channels) into dst (len(dst) channels),
+// up- or down-mixing between mono, stereo, and 5.1 using simple,
+// commonly-used coefficients.
+func remix(dst, src []float32) {
+	srcCh, dstCh := len(src), len(dst)
+
+	switch {
+	case srcCh == dstCh:
+		copy(dst, src)
+
+	case srcCh == 1:
+		// Mono to N channels: duplicate into the front left/right
+		// channels (or the single output channel), leaving any
+		// surround/LFE channels silent.
+		for ch := range dst {
+			dst[ch] = 0
+		}
+		for ch := 0; ch < dstCh && ch < 2; ch++ {
+			dst[ch] = src[0]
+		}
+
+	case dstCh == 1:
+		// N channels to mono: average every source channel.
+		var sum float32
+		for _, s := range src {
+			sum += s
+		}
+		dst[0] = sum / float32(srcCh)
+
+	case srcCh == 2 && dstCh == 6:
+		// Stereo to 5.1: front left/right pass through, center derived
+		// from their average, surrounds echo the stereo mix, LFE silent.
+		l, rr := src[0], src[1]
+		dst[0] = l
+		dst[1] = rr
+		dst[2] = (l + rr) / 2
+		dst[3] = 0
+		dst[4] = l
+		dst[5] = rr
+
+	case srcCh == 6 && dstCh == 2:
+		// 5.1 to stereo: fold center and surrounds into the front pair
+		// using the ITU-R BS.775 downmix coefficients, then apply the same
+		// kind of headroom as the dstCh == 1 case above (dividing by the
+		// total mix weight) so three full-scale channels can't clip.
+		const centerMix = 0.707
+		const surroundMix = 0.707
+		const headroom = 1 / (1 + centerMix + surroundMix)
+		fl, fr, fc, rl, rrr := src[0], src[1], src[2], src[4], src[5]
+		dst[0] = headroom * (fl + centerMix*fc + surroundMix*rl)
+		dst[1] = headroom * (fr + centerMix*fc + surroundMix*rrr)
+
+	default:
+		// No specific mapping for this pair of channel counts: copy the
+		// overlapping channels and silence the rest.
+		n := srcCh
+		if dstCh < n {
+			n = dstCh
+		}
+		copy(dst[:n], src[:n])
+		for ch := n; ch < dstCh; ch++ {
+			dst[ch] = 0
+		}
+	}
+}
@@ -0,0 +1,206 @@
+// Copyright 2022 The Oto Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package resample
+
+import (
+	"math"
+	"testing"
+)
+
+func TestRemixSameChannelCount(t *testing.T) {
+	src := []float32{0.1, 0.2, 0.3}
+	dst := make([]float32, 3)
+	remix(dst, src)
+	if dst[0] != 0.1 || dst[1] != 0.2 || dst[2] != 0.3 {
+		t.Errorf("got %v, want %v", dst, src)
+	}
+}
+
+func TestRemixMonoToStereo(t *testing.T) {
+	dst := make([]float32, 2)
+	remix(dst, []float32{0.5})
+	if dst[0] != 0.5 || dst[1] != 0.5 {
+		t.Errorf("got %v, want [0.5 0.5]", dst)
+	}
+}
+
+func TestRemixMonoTo51(t *testing.T) {
+	dst := make([]float32, 6)
+	remix(dst, []float32{0.5})
+	want := []float32{0.5, 0.5, 0, 0, 0, 0}
+	for ch := range want {
+		if dst[ch] != want[ch] {
+			t.Errorf("got %v, want %v", dst, want)
+			break
+		}
+	}
+}
+
+func TestRemixStereoToMono(t *testing.T) {
+	dst := make([]float32, 1)
+	remix(dst, []float32{1, 0})
+	if dst[0] != 0.5 {
+		t.Errorf("got %v, want 0.5", dst[0])
+	}
+}
+
+func TestRemixStereoTo51(t *testing.T) {
+	dst := make([]float32, 6)
+	remix(dst, []float32{0.2, 0.4})
+	want := []float32{0.2, 0.4, 0.3, 0, 0.2, 0.4}
+	for ch := range want {
+		if dst[ch] != want[ch] {
+			t.Errorf("got %v, want %v", dst, want)
+			break
+		}
+	}
+}
+
+func TestRemix51ToStereoNoClipping(t *testing.T) {
+	// A fully correlated full-scale 5.1 signal is the worst case for
+	// clipping: every channel contributing to a downmixed output is at
+	// +1. The dstCh == 1 case avoids this by dividing by the channel
+	// count; the srcCh == 6 && dstCh == 2 case must do the same.
+	src := []float32{1, 1, 1, 1, 1, 1}
+	dst := make([]float32, 2)
+	remix(dst, src)
+	for ch, v := range dst {
+		if v > 1+1e-4 {
+			t.Errorf("dst[%d] = %v, want <= 1 (clipping)", ch, v)
+		}
+	}
+}
+
+func TestRemix51ToStereoHeadroomIsFixed(t *testing.T) {
+	// The headroom applied to avoid clipping is a fixed mix-weight
+	// normalization, not content-adaptive, so it attenuates the front
+	// channels too, even when center/surrounds are silent.
+	src := []float32{0.5, -0.5, 0, 0, 0, 0}
+	dst := make([]float32, 2)
+	remix(dst, src)
+	const headroom = 1 / (1 + 0.707 + 0.707)
+	want0, want1 := float32(0.5*headroom), float32(-0.5*headroom)
+	if math.Abs(float64(dst[0]-want0)) > 1e-4 || math.Abs(float64(dst[1]-want1)) > 1e-4 {
+		t.Errorf("got %v, want [%v %v]", dst, want0, want1)
+	}
+}
+
+func TestRemixFallback(t *testing.T) {
+	// No specific mapping exists for, e.g., quad to mono+LFE (2
+	// channels): the overlapping channels are copied and the rest
+	// silenced.
+	dst := make([]float32, 2)
+	remix(dst, []float32{0.1, 0.2, 0.3, 0.4})
+	if dst[0] != 0.1 || dst[1] != 0.2 {
+		t.Errorf("got %v, want [0.1 0.2]", dst)
+	}
+
+	dst = make([]float32, 4)
+	remix(dst, []float32{0.1, 0.2})
+	want := []float32{0.1, 0.2, 0, 0}
+	for ch := range want {
+		if dst[ch] != want[ch] {
+			t.Errorf("got %v, want %v", dst, want)
+			break
+		}
+	}
+}
+
+// constRead returns a read func that always fills its argument with frames
+// of value v, repeated across every channel.
+func constRead(v float32) func([]float32) {
+	return func(buf []float32) {
+		for i := range buf {
+			buf[i] = v
+		}
+	}
+}
+
+func TestResamplerSameRateSameChannels(t *testing.T) {
+	r := New(48000, 2, 48000, 2)
+	dst := make([]float32, 2*10)
+	r.Read(dst, constRead(0.25))
+	for i, v := range dst {
+		if v != 0.25 {
+			t.Fatalf("dst[%d] = %v, want 0.25", i, v)
+		}
+	}
+}
+
+// rampRead returns a read func that fills its argument with consecutive
+// integers starting from *frame, advancing *frame by the number of frames
+// filled (len(buf)/channels).
+func rampRead(frame *int, channels int) func([]float32) {
+	return func(buf []float32) {
+		for i := 0; i < len(buf)/channels; i++ {
+			for ch := 0; ch < channels; ch++ {
+				buf[i*channels+ch] = float32(*frame)
+			}
+			*frame++
+		}
+	}
+}
+
+func TestResamplerUpsample(t *testing.T) {
+	// 24000 -> 48000 is a simple 2x upsample: each source frame appears at
+	// every other output sample, with the linearly interpolated midpoint
+	// between neighbours in the samples between them. The output lags by one
+	// source frame (two output samples) because of priming (there's no real
+	// frame before the first one, so it's duplicated).
+	r := New(24000, 1, 48000, 1)
+
+	var frame int
+	dst := make([]float32, 16)
+	r.Read(dst, rampRead(&frame, 1))
+
+	for i := 2; i < len(dst); i++ {
+		want := float32(i-2) / 2
+		if math.Abs(float64(dst[i]-want)) > 1e-4 {
+			t.Errorf("dst[%d] = %v, want %v", i, dst[i], want)
+		}
+	}
+}
+
+func TestResamplerDownsample(t *testing.T) {
+	// A constant-valued source should downsample to the same constant,
+	// regardless of rate.
+	r := New(48000, 1, 24000, 1)
+	dst := make([]float32, 16)
+	r.Read(dst, constRead(0.5))
+	for i, v := range dst {
+		if v != 0.5 {
+			t.Fatalf("dst[%d] = %v, want 0.5", i, v)
+		}
+	}
+}
+
+func TestResamplerReadsAcrossBlockBoundary(t *testing.T) {
+	// Request more output than fits in a single internal block (see
+	// blockFrames) to exercise fillBlock being called more than once,
+	// including the carry-over of the last frame of a block into the
+	// first frame of the next.
+	r := New(48000, 1, 48000, 1)
+
+	var frame int
+	dst := make([]float32, blockFrames*3)
+	r.Read(dst, rampRead(&frame, 1))
+
+	for i := 1; i < len(dst); i++ {
+		want := float32(i - 1)
+		if dst[i] != want {
+			t.Fatalf("dst[%d] = %v, want %v", i, dst[i], want)
+		}
+	}
+}
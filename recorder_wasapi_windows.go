@@ -0,0 +1,335 @@
+// Copyright 2022 The Oto Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package oto
+
+import (
+	"fmt"
+	"reflect"
+	"runtime"
+	"sync"
+	"unsafe"
+
+	"golang.org/x/sys/windows"
+)
+
+// Recorder captures system audio output via WASAPI loopback capture and
+// delivers it to onSamples as interleaved float32 PCM. Recorder is
+// currently only implemented on Windows.
+//
+// The captured sample rate and channel count are determined by the render
+// endpoint's mix format and are available via SampleRate and ChannelCount
+// once NewRecorder returns.
+type Recorder struct {
+	ctx *wasapiLoopbackContext
+}
+
+// NewRecorder starts loopback capture of the render endpoint identified by
+// deviceID (as returned by EnumerateDevices), or the default render
+// endpoint if deviceID is empty.
+//
+// onSamples is called from an internal goroutine with each captured buffer
+// of interleaved float32 PCM. The slice is reused between calls, so
+// onSamples must not retain it.
+func NewRecorder(deviceID string, onSamples func(samples []float32)) (*Recorder, error) {
+	ctx, err := newWASAPILoopbackContext(deviceID, onSamples)
+	if err != nil {
+		return nil, err
+	}
+	return &Recorder{ctx: ctx}, nil
+}
+
+// SampleRate returns the sample rate audio is captured at.
+func (r *Recorder) SampleRate() int {
+	return r.ctx.sampleRate
+}
+
+// ChannelCount returns the channel count audio is captured at.
+func (r *Recorder) ChannelCount() int {
+	return r.ctx.channelCount
+}
+
+// Stop stops capture and releases the underlying WASAPI resources.
+func (r *Recorder) Stop() error {
+	return r.ctx.Stop()
+}
+
+// Err returns an error if the recorder's capture loop has failed.
+func (r *Recorder) Err() error {
+	return r.ctx.Err()
+}
+
+// wasapiLoopbackContext is the capture counterpart to wasapiContext: it
+// opens the render endpoint given by deviceID (the default render endpoint
+// when deviceID is empty) with AUDCLNT_STREAMFLAGS_LOOPBACK
+// instead of rendering to it, and pulls captured packets via
+// IAudioCaptureClient instead of pushing via IAudioRenderClient.
+type wasapiLoopbackContext struct {
+	sampleRate   int
+	channelCount int
+	deviceID     string
+
+	comThread *comThread
+	err       atomicError
+
+	// stop guards Stop's teardown against running more than once; see
+	// stopGuard.
+	stop stopGuard
+
+	sampleReadyEvent windows.Handle
+	stopEvent        windows.Handle // signaled by Stop to ask the capture goroutine to exit
+	client           *_IAudioClient2
+	mixFormat        *_WAVEFORMATEXTENSIBLE
+	captureClient    *_IAudioCaptureClient
+
+	write func([]float32)
+	buf   []float32
+
+	done chan struct{} // closed once the capture goroutine has exited
+
+	m sync.Mutex
+}
+
+func newWASAPILoopbackContext(deviceID string, write func([]float32)) (*wasapiLoopbackContext, error) {
+	t, err := newCOMThread()
+	if err != nil {
+		return nil, err
+	}
+
+	c := &wasapiLoopbackContext{
+		deviceID:  deviceID,
+		write:     write,
+		comThread: t,
+	}
+
+	var cerr error
+	t.Run(func() {
+		if err := c.initOnCOMThread(); err != nil {
+			cerr = err
+			return
+		}
+	})
+	if cerr != nil {
+		return nil, cerr
+	}
+
+	return c, nil
+}
+
+func (c *wasapiLoopbackContext) initOnCOMThread() error {
+	e, err := _CoCreateInstance(&uuidMMDeviceEnumerator, nil, uint32(_CLSCTX_ALL), &uuidIMMDeviceEnumerator)
+	if err != nil {
+		return err
+	}
+	enumerator := (*_IMMDeviceEnumerator)(e)
+	defer enumerator.Release()
+
+	var device *_IMMDevice
+	if c.deviceID != "" {
+		device, err = enumerator.GetDevice(c.deviceID)
+	} else {
+		device, err = enumerator.GetDefaultAudioEndPoint(eRender, eConsole)
+	}
+	if err != nil {
+		return err
+	}
+	defer device.Release()
+
+	client, err := device.Activate(&uuidIAudioClient2, uint32(_CLSCTX_ALL), nil)
+	if err != nil {
+		return err
+	}
+	c.client = (*_IAudioClient2)(client)
+
+	// Loopback capture must use the render endpoint's own mix format;
+	// WASAPI doesn't resample or remix on the way into a loopback stream.
+	mixFormat, err := c.client.GetMixFormat()
+	if err != nil {
+		return err
+	}
+	c.mixFormat = mixFormat
+	c.sampleRate = int(mixFormat.nSamplesPerSec)
+	c.channelCount = int(mixFormat.nChannels)
+
+	if err := c.client.Initialize(_AUDCLNT_SHAREMODE_SHARED,
+		_AUDCLNT_STREAMFLAGS_EVENTCALLBACK|_AUDCLNT_STREAMFLAGS_LOOPBACK|_AUDCLNT_STREAMFLAGS_NOPERSIST,
+		0, 0, c.mixFormat, nil); err != nil {
+		return err
+	}
+
+	ev, err := windows.CreateEventEx(nil, nil, 0, windows.EVENT_ALL_ACCESS)
+	if err != nil {
+		return err
+	}
+	c.sampleReadyEvent = ev
+
+	stopEv, err := windows.CreateEventEx(nil, nil, 0, windows.EVENT_ALL_ACCESS)
+	if err != nil {
+		return err
+	}
+	c.stopEvent = stopEv
+	c.done = make(chan struct{})
+
+	if err := c.client.SetEventHandle(c.sampleReadyEvent); err != nil {
+		return err
+	}
+
+	captureClient, err := c.client.GetService(&uuidIAudioCaptureClient)
+	if err != nil {
+		return err
+	}
+	c.captureClient = (*_IAudioCaptureClient)(captureClient)
+
+	if err := c.client.Start(); err != nil {
+		return err
+	}
+
+	go func() {
+		defer close(c.done)
+
+		runtime.LockOSThread()
+		defer runtime.UnlockOSThread()
+
+		if err := _CoInitializeEx(nil, _COINIT_MULTITHREADED); err != nil {
+			c.client.Stop()
+			c.err.TryStore(err)
+			return
+		}
+		defer _CoUninitialize()
+
+		if err := c.loopOnCaptureThread(); err != nil {
+			c.client.Stop()
+			c.err.TryStore(err)
+			return
+		}
+	}()
+
+	return nil
+}
+
+// loopOnCaptureThread waits on both sampleReadyEvent and stopEvent so that
+// Stop can ask this goroutine to exit without closing a handle it might
+// still be blocked on.
+func (c *wasapiLoopbackContext) loopOnCaptureThread() error {
+	handles := []windows.Handle{c.sampleReadyEvent, c.stopEvent}
+	for {
+		evt, err := windows.WaitForMultipleObjects(handles, false, windows.INFINITE)
+		if err != nil {
+			return err
+		}
+		switch evt {
+		case windows.WAIT_OBJECT_0:
+			if err := c.readOnCaptureThread(); err != nil {
+				return err
+			}
+		case windows.WAIT_OBJECT_0 + 1:
+			// Stop asked us to exit.
+			return nil
+		default:
+			return fmt.Errorf("oto: WaitForMultipleObjects failed: returned value: %d", evt)
+		}
+	}
+}
+
+func (c *wasapiLoopbackContext) readOnCaptureThread() error {
+	c.m.Lock()
+	defer c.m.Unlock()
+
+	for {
+		packetFrames, err := c.captureClient.GetNextPacketSize()
+		if err != nil {
+			return err
+		}
+		if packetFrames == 0 {
+			return nil
+		}
+
+		srcBuf, frames, flags, err := c.captureClient.GetBuffer()
+		if err != nil {
+			return err
+		}
+
+		buflen := int(frames) * c.channelCount
+		if cap(c.buf) < buflen {
+			c.buf = make([]float32, buflen)
+		} else {
+			c.buf = c.buf[:buflen]
+		}
+
+		if flags&_AUDCLNT_BUFFERFLAGS_SILENT != 0 || srcBuf == nil {
+			// Nothing is playing right now. Deliver zeros so consumers
+			// see a continuous stream rather than a gap.
+			for i := range c.buf {
+				c.buf[i] = 0
+			}
+		} else {
+			var src []float32
+			h := (*reflect.SliceHeader)(unsafe.Pointer(&src))
+			h.Data = uintptr(unsafe.Pointer(srcBuf))
+			h.Len = buflen
+			h.Cap = buflen
+			copy(c.buf, src)
+		}
+
+		if err := c.captureClient.ReleaseBuffer(frames); err != nil {
+			return err
+		}
+
+		if c.write != nil {
+			c.write(c.buf)
+		}
+	}
+}
+
+// Stop stops capture and releases the underlying WASAPI resources. It is
+// safe to call more than once, or concurrently with itself; only the first
+// call does anything.
+func (c *wasapiLoopbackContext) Stop() error {
+	if !c.stop.enter() {
+		return nil
+	}
+
+	// Ask the capture goroutine to exit and wait for it to actually do so
+	// before releasing or closing anything it might still be using;
+	// closing a handle another thread is blocked on is undefined behavior.
+	windows.SetEvent(c.stopEvent)
+	<-c.done
+
+	var cerr error
+	c.comThread.Run(func() {
+		c.m.Lock()
+		defer c.m.Unlock()
+
+		if err := c.client.Stop(); err != nil {
+			cerr = err
+			return
+		}
+		c.captureClient.Release()
+		c.client.Release()
+		windows.CloseHandle(c.sampleReadyEvent)
+		windows.CloseHandle(c.stopEvent)
+	})
+	if cerr != nil {
+		return cerr
+	}
+
+	// There's nothing left to wait on the COM thread for, so stop it too.
+	close(c.comThread.funcCh)
+
+	return nil
+}
+
+func (c *wasapiLoopbackContext) Err() error {
+	return c.err.Load()
+}
@@ -0,0 +1,39 @@
+// Copyright 2022 The Oto Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package oto
+
+import "sync"
+
+// stopGuard makes a teardown action idempotent and safe to call from
+// multiple goroutines. A Stop/Close method should run its teardown (closing
+// handles, releasing COM objects, etc.) only when enter reports true;
+// subsequent or concurrent calls become no-ops instead of tearing down the
+// same resources twice.
+type stopGuard struct {
+	mu      sync.Mutex
+	stopped bool
+}
+
+// enter reports whether the caller is the first to reach this point. It
+// returns true exactly once for the lifetime of g.
+func (g *stopGuard) enter() bool {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if g.stopped {
+		return false
+	}
+	g.stopped = true
+	return true
+}
@@ -0,0 +1,57 @@
+// Copyright 2022 The Oto Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package oto
+
+import (
+	"sync"
+	"testing"
+)
+
+func TestStopGuardEntersOnce(t *testing.T) {
+	var g stopGuard
+	if !g.enter() {
+		t.Fatal("first enter() should return true")
+	}
+	if g.enter() {
+		t.Fatal("second enter() should return false")
+	}
+	if g.enter() {
+		t.Fatal("third enter() should return false")
+	}
+}
+
+func TestStopGuardConcurrent(t *testing.T) {
+	var g stopGuard
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	winners := 0
+
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if g.enter() {
+				mu.Lock()
+				winners++
+				mu.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+
+	if winners != 1 {
+		t.Fatalf("got %d winners, want exactly 1", winners)
+	}
+}